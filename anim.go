@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adammhaile/SpectraFilm/apng"
+)
+
+// animPaletteSize is the number of colors clustered out of the frame
+// averages to build the animation's shared palette. GIF/APNG-via-paletted-PNG
+// are both limited to 256 colors per frame.
+const animPaletteSize = 255
+
+// buildAnimPalette derives a color.Palette by clustering every frame's
+// average color into animPaletteSize dominant colors, reusing the same
+// k-means routine getKMeans uses for the per-frame dominant-color image.
+func buildAnimPalette(frames []Frame) color.Palette {
+	vals := make(RGBList, len(frames))
+	for i, f := range frames {
+		vals[i] = f.Average
+	}
+
+	dominant := getKMeans(vals, animPaletteSize, 0)
+
+	pal := make(color.Palette, len(dominant))
+	for i, c := range dominant {
+		pal[i] = c.RGBA()
+	}
+	return pal
+}
+
+// ditherFrame quantizes src to pal using Floyd-Steinberg dithering.
+func ditherFrame(src *image.RGBA, pal color.Palette) *image.Paletted {
+	bounds := src.Bounds()
+	dst := image.NewPaletted(bounds, pal)
+	draw.FloydSteinberg.Draw(dst, bounds, src, image.Point{})
+	return dst
+}
+
+// renderAnim writes an animated GIF or APNG (chosen by path's extension)
+// showing the average-color barcode being drawn one line at a time. Total
+// playback length is derived from durationSeconds so it stays in sync with
+// the source video regardless of frame count.
+//
+// Each step is drawn onto a single reusable RGBA canvas and dithered
+// straight into the final per-format frame slice; earlier designs kept a
+// full-size RGBA snapshot per step alive simultaneously, which for a
+// feature-length barcode runs into gigabytes of resident memory.
+func renderAnim(frames []Frame, path string, durationSeconds float64) error {
+	fmt.Println("Generating " + path)
+
+	vals := make(RGBList, len(frames))
+	for i, f := range frames {
+		vals[i] = f.Average
+	}
+
+	pal := buildAnimPalette(frames)
+
+	delay := int(durationSeconds * 100 / float64(len(vals)))
+	if delay < 1 {
+		delay = 1
+	}
+
+	bounds := image.Rect(0, 0, width, len(vals)*lineHeight)
+	canvas := image.NewRGBA(bounds)
+	draw.Draw(canvas, bounds, image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	isAPNG := strings.ToLower(filepath.Ext(path)) == ".apng"
+
+	var gifImages []*image.Paletted
+	var gifDelays []int
+	var apngFrames []apng.Frame
+	if isAPNG {
+		apngFrames = make([]apng.Frame, len(vals))
+	} else {
+		gifImages = make([]*image.Paletted, len(vals))
+		gifDelays = make([]int, len(vals))
+	}
+
+	for y, c := range vals {
+		rgba := c.RGBA()
+		for i := 0; i < lineHeight; i++ {
+			for x := 0; x < width; x++ {
+				canvas.Set(x, y*lineHeight+i, rgba)
+			}
+		}
+
+		dithered := ditherFrame(canvas, pal)
+		if isAPNG {
+			apngFrames[y] = apng.Frame{Image: dithered, Delay: delay}
+		} else {
+			gifImages[y] = dithered
+			gifDelays[y] = delay
+		}
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if isAPNG {
+		return apng.EncodeAll(out, apngFrames, 0)
+	}
+
+	return gif.EncodeAll(out, &gif.GIF{Image: gifImages, Delay: gifDelays, LoopCount: 0})
+}
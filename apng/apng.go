@@ -0,0 +1,187 @@
+// Package apng encodes an Animated PNG (APNG) from a sequence of images.
+// The standard library's image/png has no animation support, so each frame
+// is first encoded as an ordinary PNG, then its IDAT data is repackaged as
+// the fdAT chunks the APNG spec (https://wiki.mozilla.org/APNG_Specification)
+// expects, sitting alongside the acTL/fcTL chunks that describe timing.
+package apng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+// Frame is one frame of the animation: the image to encode and how long it
+// should be displayed, in hundredths of a second (matching image/gif's Delay
+// convention).
+type Frame struct {
+	Image image.Image
+	Delay int
+}
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+
+type chunk struct {
+	typ  string
+	data []byte
+}
+
+// splitChunks parses a complete PNG byte stream into its chunks, dropping the
+// per-chunk CRC (recomputed on write) and the leading signature.
+func splitChunks(data []byte) ([]chunk, error) {
+	if len(data) < 8 || !bytes.Equal(data[:8], pngSignature) {
+		return nil, fmt.Errorf("apng: not a PNG stream")
+	}
+	data = data[8:]
+
+	var chunks []chunk
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, fmt.Errorf("apng: truncated chunk")
+		}
+		length := binary.BigEndian.Uint32(data[:4])
+		typ := string(data[4:8])
+		body := data[8 : 8+length]
+		chunks = append(chunks, chunk{typ, append([]byte(nil), body...)})
+		data = data[8+length+4:]
+	}
+
+	return chunks, nil
+}
+
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, typ); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+
+	var crcBuf [4]byte
+	binary.BigEndian.PutUint32(crcBuf[:], crc.Sum32())
+	_, err := w.Write(crcBuf[:])
+	return err
+}
+
+// fcTLData builds the 26-byte body of an fcTL (frame control) chunk.
+func fcTLData(seq uint32, w, h, xOff, yOff int, delayNum, delayDen uint16) []byte {
+	buf := make([]byte, 26)
+	binary.BigEndian.PutUint32(buf[0:4], seq)
+	binary.BigEndian.PutUint32(buf[4:8], uint32(w))
+	binary.BigEndian.PutUint32(buf[8:12], uint32(h))
+	binary.BigEndian.PutUint32(buf[12:16], uint32(xOff))
+	binary.BigEndian.PutUint32(buf[16:20], uint32(yOff))
+	binary.BigEndian.PutUint16(buf[20:22], delayNum)
+	binary.BigEndian.PutUint16(buf[22:24], delayDen)
+	buf[24] = 0 // dispose_op: APNG_DISPOSE_OP_NONE
+	buf[25] = 0 // blend_op: APNG_BLEND_OP_SOURCE
+	return buf
+}
+
+// EncodeAll writes frames as an animated PNG to w. loopCount is the number of
+// times the animation repeats; 0 means loop forever.
+func EncodeAll(w io.Writer, frames []Frame, loopCount int) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("apng: no frames to encode")
+	}
+
+	perFrame := make([][]chunk, len(frames))
+	for i, f := range frames {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, f.Image); err != nil {
+			return err
+		}
+		chunks, err := splitChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		perFrame[i] = chunks
+	}
+
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+
+	// Palette chunks are taken from the first frame only, so every frame is
+	// encoded against that one palette.
+	var ihdr, idat0 []byte
+	var palette []chunk
+	for _, c := range perFrame[0] {
+		switch c.typ {
+		case "IHDR":
+			ihdr = c.data
+		case "PLTE", "tRNS":
+			palette = append(palette, c)
+		case "IDAT":
+			idat0 = append(idat0, c.data...)
+		}
+	}
+	if ihdr == nil || idat0 == nil {
+		return fmt.Errorf("apng: first frame is missing IHDR/IDAT")
+	}
+	if err := writeChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+
+	acTL := make([]byte, 8)
+	binary.BigEndian.PutUint32(acTL[0:4], uint32(len(frames)))
+	binary.BigEndian.PutUint32(acTL[4:8], uint32(loopCount))
+	if err := writeChunk(w, "acTL", acTL); err != nil {
+		return err
+	}
+	for _, c := range palette {
+		if err := writeChunk(w, c.typ, c.data); err != nil {
+			return err
+		}
+	}
+
+	seq := uint32(0)
+	bounds := frames[0].Image.Bounds()
+
+	if err := writeChunk(w, "fcTL", fcTLData(seq, bounds.Dx(), bounds.Dy(), 0, 0, uint16(frames[0].Delay), 100)); err != nil {
+		return err
+	}
+	seq++
+	if err := writeChunk(w, "IDAT", idat0); err != nil {
+		return err
+	}
+
+	for i := 1; i < len(frames); i++ {
+		var idat []byte
+		for _, c := range perFrame[i] {
+			if c.typ == "IDAT" {
+				idat = append(idat, c.data...)
+			}
+		}
+
+		b := frames[i].Image.Bounds()
+		if err := writeChunk(w, "fcTL", fcTLData(seq, b.Dx(), b.Dy(), 0, 0, uint16(frames[i].Delay), 100)); err != nil {
+			return err
+		}
+		seq++
+
+		fdAT := make([]byte, 4+len(idat))
+		binary.BigEndian.PutUint32(fdAT[:4], seq)
+		copy(fdAT[4:], idat)
+		seq++
+		if err := writeChunk(w, "fdAT", fdAT); err != nil {
+			return err
+		}
+	}
+
+	return writeChunk(w, "IEND", nil)
+}
@@ -0,0 +1,150 @@
+// Package colorspace converts 8-bit gamma-encoded RGB into linear light, CIE
+// XYZ, and CIE L*a*b*, so color math (averaging, distance, clustering) can be
+// done in a perceptually or physically meaningful space instead of on raw
+// gamma-encoded bytes.
+//
+// All XYZ/Lab conversions use the CIE 1931 D65 white point
+// (Xn=0.95047, Yn=1.0, Zn=1.08883), matching the reference white of both
+// Rec.709 and Rec.2020.
+package colorspace
+
+import (
+	"fmt"
+	"math"
+)
+
+// Space identifies a working colorspace's RGB primaries.
+type Space int
+
+const (
+	// Rec709 is the sRGB/Rec.709 primary set used by most web and SD/HD video.
+	Rec709 Space = iota
+	// Rec2020 is the wide-gamut primary set used by UHD/HDR video.
+	Rec2020
+)
+
+// ParseSpace resolves a -colorspace flag value to a Space.
+func ParseSpace(name string) (Space, error) {
+	switch name {
+	case "709", "rec709", "Rec709":
+		return Rec709, nil
+	case "2020", "rec2020", "Rec2020":
+		return Rec2020, nil
+	default:
+		return Rec709, fmt.Errorf("colorspace: unknown working colorspace %q (want \"709\" or \"2020\")", name)
+	}
+}
+
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+// EOTF decodes a gamma-encoded channel value (0..1) into linear light, using
+// the sRGB piecewise transfer function (for every working colorspace).
+func EOTF(c float64) float64 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math.Pow((c+0.055)/1.055, 2.4)
+}
+
+// OETF encodes a linear-light channel value (0..1) back to gamma space,
+// inverting EOTF.
+func OETF(c float64) float64 {
+	if c <= 0.0031308 {
+		return c * 12.92
+	}
+	return 1.055*math.Pow(c, 1/2.4) - 0.055
+}
+
+// ToXYZ converts a linear-light RGB triple in the given working colorspace to
+// CIE XYZ (D65).
+func (s Space) ToXYZ(r, g, b float64) (x, y, z float64) {
+	m := rgbToXYZ[s]
+	x = m[0][0]*r + m[0][1]*g + m[0][2]*b
+	y = m[1][0]*r + m[1][1]*g + m[1][2]*b
+	z = m[2][0]*r + m[2][1]*g + m[2][2]*b
+	return
+}
+
+// FromXYZ converts CIE XYZ (D65) back to linear-light RGB in the given
+// working colorspace.
+func (s Space) FromXYZ(x, y, z float64) (r, g, b float64) {
+	m := xyzToRGB[s]
+	r = m[0][0]*x + m[0][1]*y + m[0][2]*z
+	g = m[1][0]*x + m[1][1]*y + m[1][2]*z
+	b = m[2][0]*x + m[2][1]*y + m[2][2]*z
+	return
+}
+
+// rgbToXYZ holds the linear-RGB -> XYZ (D65) primaries matrix for each Space.
+var rgbToXYZ = map[Space][3][3]float64{
+	Rec709: {
+		{0.4124564, 0.3575761, 0.1804375},
+		{0.2126729, 0.7151522, 0.0721750},
+		{0.0193339, 0.1191920, 0.9503041},
+	},
+	Rec2020: {
+		{0.6369580, 0.1446169, 0.1688810},
+		{0.2627002, 0.6779981, 0.0593017},
+		{0.0000000, 0.0280727, 1.0609851},
+	},
+}
+
+// xyzToRGB holds the inverse of rgbToXYZ for each Space.
+var xyzToRGB = map[Space][3][3]float64{
+	Rec709: {
+		{3.2404542, -1.5371385, -0.4985314},
+		{-0.9692660, 1.8760108, 0.0415560},
+		{0.0556434, -0.2040259, 1.0572252},
+	},
+	Rec2020: {
+		{1.7166512, -0.3556708, -0.2533663},
+		{-0.6666844, 1.6164812, 0.0157685},
+		{0.0176399, -0.0427706, 0.9421031},
+	},
+}
+
+// XYZToLab converts CIE XYZ (D65) to CIE L*a*b*.
+func XYZToLab(x, y, z float64) (l, a, b float64) {
+	fx := labF(x / whiteX)
+	fy := labF(y / whiteY)
+	fz := labF(z / whiteZ)
+
+	l = 116*fy - 16
+	a = 500 * (fx - fy)
+	b = 200 * (fy - fz)
+	return
+}
+
+// LabToXYZ converts CIE L*a*b* back to CIE XYZ (D65).
+func LabToXYZ(l, a, b float64) (x, y, z float64) {
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+
+	x = whiteX * labFInv(fx)
+	y = whiteY * labFInv(fy)
+	z = whiteZ * labFInv(fz)
+	return
+}
+
+const (
+	labDelta = 6.0 / 29.0
+)
+
+func labF(t float64) float64 {
+	if t > labDelta*labDelta*labDelta {
+		return math.Cbrt(t)
+	}
+	return t/(3*labDelta*labDelta) + 4.0/29.0
+}
+
+func labFInv(t float64) float64 {
+	if t > labDelta {
+		return t * t * t
+	}
+	return 3 * labDelta * labDelta * (t - 4.0/29.0)
+}
@@ -0,0 +1,169 @@
+// Package kmeans clusters weighted 3D points (e.g. linear RGB or Lab colors)
+// into a fixed number of dominant centroids using k-means++ initialization
+// followed by Lloyd's algorithm.
+package kmeans
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Point is a 3-dimensional vector being clustered.
+type Point [3]float64
+
+// Sample is a point paired with how many source observations it represents,
+// so a caller can cluster over a deduplicated histogram instead of every
+// individual pixel.
+type Sample struct {
+	Point  Point
+	Weight float64
+}
+
+// Cluster is a centroid plus the total weight of the samples assigned to it.
+type Cluster struct {
+	Centroid Point
+	Weight   float64
+}
+
+// Options controls the Lloyd's-algorithm iteration.
+type Options struct {
+	MaxIterations int
+	// Threshold is the total centroid movement (summed Euclidean distance)
+	// below which iteration stops early.
+	Threshold float64
+}
+
+// DefaultOptions returns reasonable defaults for clustering per-frame pixel
+// histograms.
+func DefaultOptions() Options {
+	return Options{MaxIterations: 50, Threshold: 1e-4}
+}
+
+func sqDist(a, b Point) float64 {
+	dx := a[0] - b[0]
+	dy := a[1] - b[1]
+	dz := a[2] - b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+// initPlusPlus picks k initial centroids via k-means++: the first uniformly
+// at random, then each subsequent one with probability proportional to its
+// weighted squared distance to the nearest centroid chosen so far.
+func initPlusPlus(samples []Sample, k int, rng *rand.Rand) []Point {
+	n := len(samples)
+	centroids := make([]Point, 0, k)
+
+	first := samples[rng.Intn(n)].Point
+	centroids = append(centroids, first)
+
+	dist := make([]float64, n)
+	for i, s := range samples {
+		dist[i] = sqDist(s.Point, first)
+	}
+
+	for len(centroids) < k {
+		var total float64
+		for i, d := range dist {
+			total += d * samples[i].Weight
+		}
+
+		if total == 0 {
+			centroids = append(centroids, samples[rng.Intn(n)].Point)
+			continue
+		}
+
+		target := rng.Float64() * total
+		var cum float64
+		chosen := n - 1
+		for i, d := range dist {
+			cum += d * samples[i].Weight
+			if cum >= target {
+				chosen = i
+				break
+			}
+		}
+
+		next := samples[chosen].Point
+		centroids = append(centroids, next)
+
+		for i, s := range samples {
+			if d := sqDist(s.Point, next); d < dist[i] {
+				dist[i] = d
+			}
+		}
+	}
+
+	return centroids
+}
+
+// Run clusters samples into k centroids, returning clusters sorted
+// largest-weight-first.
+func Run(samples []Sample, k int, opts Options) []Cluster {
+	if len(samples) == 0 || k <= 0 {
+		return nil
+	}
+	if k > len(samples) {
+		k = len(samples)
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	centroids := initPlusPlus(samples, k, rng)
+
+	assign := make([]int, len(samples))
+
+	for iter := 0; iter < opts.MaxIterations; iter++ {
+		for i, s := range samples {
+			best, bestDist := 0, math.Inf(1)
+			for c, centroid := range centroids {
+				if d := sqDist(s.Point, centroid); d < bestDist {
+					best, bestDist = c, d
+				}
+			}
+			assign[i] = best
+		}
+
+		sums := make([]Point, k)
+		weights := make([]float64, k)
+		for i, s := range samples {
+			c := assign[i]
+			sums[c][0] += s.Point[0] * s.Weight
+			sums[c][1] += s.Point[1] * s.Weight
+			sums[c][2] += s.Point[2] * s.Weight
+			weights[c] += s.Weight
+		}
+
+		var movement float64
+		for c := range centroids {
+			if weights[c] == 0 {
+				continue
+			}
+			next := Point{
+				sums[c][0] / weights[c],
+				sums[c][1] / weights[c],
+				sums[c][2] / weights[c],
+			}
+			movement += math.Sqrt(sqDist(next, centroids[c]))
+			centroids[c] = next
+		}
+
+		if movement < opts.Threshold {
+			break
+		}
+	}
+
+	weights := make([]float64, k)
+	for i, s := range samples {
+		weights[assign[i]] += s.Weight
+	}
+
+	clusters := make([]Cluster, k)
+	for c := range centroids {
+		clusters[c] = Cluster{Centroid: centroids[c], Weight: weights[c]}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Weight > clusters[j].Weight })
+
+	return clusters
+}
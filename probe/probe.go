@@ -0,0 +1,133 @@
+// Package probe runs ffprobe against a source file and parses its JSON
+// output into the subset of metadata SpectraFilm cares about: dimensions,
+// codec, bit rate, the precise (rational) frame rate, duration, rotation,
+// and color tagging.
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Info is the parsed, convenience-typed metadata for a video's first video
+// stream.
+type Info struct {
+	Width          int
+	Height         int
+	Codec          string
+	BitRate        int64
+	FPSNum         int
+	FPSDen         int
+	Duration       float64
+	Rotation       int
+	ColorSpace     string
+	ColorTransfer  string
+	ColorPrimaries string
+}
+
+type rawStream struct {
+	CodecType      string            `json:"codec_type"`
+	CodecName      string            `json:"codec_name"`
+	Width          int               `json:"width"`
+	Height         int               `json:"height"`
+	BitRate        string            `json:"bit_rate"`
+	AvgFrameRate   string            `json:"avg_frame_rate"`
+	Duration       string            `json:"duration"`
+	ColorSpace     string            `json:"color_space"`
+	ColorTransfer  string            `json:"color_transfer"`
+	ColorPrimaries string            `json:"color_primaries"`
+	Tags           map[string]string `json:"tags"`
+}
+
+type rawFormat struct {
+	Duration string `json:"duration"`
+	BitRate  string `json:"bit_rate"`
+}
+
+type rawProbe struct {
+	Streams []rawStream `json:"streams"`
+	Format  rawFormat   `json:"format"`
+}
+
+// Probe runs `ffprobe -show_format -show_streams` against filename and
+// returns the parsed metadata of its first video stream.
+func Probe(filename string) (Info, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", filename)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return Info{}, fmt.Errorf("probe: ffprobe failed: %v", err)
+	}
+
+	var raw rawProbe
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return Info{}, fmt.Errorf("probe: failed to parse ffprobe output: %v", err)
+	}
+
+	var stream *rawStream
+	for i := range raw.Streams {
+		if raw.Streams[i].CodecType == "video" {
+			stream = &raw.Streams[i]
+			break
+		}
+	}
+	if stream == nil {
+		return Info{}, fmt.Errorf("probe: %s has no video stream", filename)
+	}
+
+	info := Info{
+		Width:          stream.Width,
+		Height:         stream.Height,
+		Codec:          stream.CodecName,
+		ColorSpace:     stream.ColorSpace,
+		ColorTransfer:  stream.ColorTransfer,
+		ColorPrimaries: stream.ColorPrimaries,
+	}
+
+	info.FPSNum, info.FPSDen, err = parseRational(stream.AvgFrameRate)
+	if err != nil {
+		return Info{}, err
+	}
+
+	duration := stream.Duration
+	if duration == "" {
+		duration = raw.Format.Duration
+	}
+	info.Duration, _ = strconv.ParseFloat(duration, 64)
+
+	bitRate := stream.BitRate
+	if bitRate == "" {
+		bitRate = raw.Format.BitRate
+	}
+	info.BitRate, _ = strconv.ParseInt(bitRate, 10, 64)
+
+	if rotate, ok := stream.Tags["rotate"]; ok {
+		info.Rotation, _ = strconv.Atoi(rotate)
+	}
+
+	return info, nil
+}
+
+// parseRational parses an ffprobe "num/den" rational field such as
+// avg_frame_rate.
+func parseRational(s string) (num, den int, err error) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("probe: invalid rational %q", s)
+	}
+
+	num, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	den, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return num, den, nil
+}
@@ -16,9 +16,18 @@ import (
 	"math"
 	"os"
 	"os/exec"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adammhaile/SpectraFilm/colorspace"
+	"github.com/adammhaile/SpectraFilm/kmeans"
+	"github.com/adammhaile/SpectraFilm/probe"
+	"github.com/adammhaile/SpectraFilm/y4m"
 )
 
 //Frame is base object to hold frame info
@@ -141,6 +150,7 @@ type Frame struct {
 	Average RGB
 	Median  RGB
 	Mode    RGBList
+	KMeans  RGBList
 }
 
 type jsonFrame struct {
@@ -148,6 +158,14 @@ type jsonFrame struct {
 	Average string
 	Median  string
 	Mode    []string
+	KMeans  []string
+}
+
+// jsonOutput is the top-level shape written to data.json: the probed source
+// metadata alongside the per-frame color data.
+type jsonOutput struct {
+	Source probe.Info
+	Frames []jsonFrame
 }
 
 func (f Frame) toJSONFrame() jsonFrame {
@@ -155,7 +173,11 @@ func (f Frame) toJSONFrame() jsonFrame {
 	for i, m := range f.Mode {
 		mode[i] = m.Hex()
 	}
-	return jsonFrame{f.Path, f.Average.Hex(), f.Median.Hex(), mode}
+	var kmeans = make([]string, len(f.KMeans))
+	for i, m := range f.KMeans {
+		kmeans[i] = m.Hex()
+	}
+	return jsonFrame{f.Path, f.Average.Hex(), f.Median.Hex(), mode, kmeans}
 }
 
 func openImage(filename string) image.Image {
@@ -211,7 +233,65 @@ func pipeReader(prefix string, reader io.Reader) {
 	}
 }
 
-func ffmpeg(opts ...string) {
+// progressTracker accumulates a processed-frame count from any number of
+// goroutines and periodically prints a "processed N/total frames, X fps,
+// ETA ..." line to stderr.
+type progressTracker struct {
+	total     int
+	processed int64
+	start     time.Time
+}
+
+func newProgressTracker(total int) *progressTracker {
+	return &progressTracker{total: total, start: time.Now()}
+}
+
+func (p *progressTracker) increment() {
+	atomic.AddInt64(&p.processed, 1)
+}
+
+func (p *progressTracker) report() {
+	processed := int(atomic.LoadInt64(&p.processed))
+	fmt.Fprintln(os.Stderr, progressLine(processed, p.total, time.Since(p.start)))
+}
+
+// run prints a throttled (once/second) progress line until done is closed,
+// then prints one final line reflecting the finished count.
+func (p *progressTracker) run(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.report()
+		case <-done:
+			p.report()
+			return
+		}
+	}
+}
+
+// progressLine formats a single throttled progress update shared by the
+// frame-analysis worker pool and the ffmpeg thumbnail phase.
+func progressLine(processed, total int, elapsed time.Duration) string {
+	fps := float64(processed) / elapsed.Seconds()
+	return fmt.Sprintf("processed %d/%d frames, %.1f fps, ETA %s", processed, total, fps, etaString(processed, total, fps))
+}
+
+func etaString(processed, total int, fps float64) string {
+	if fps <= 0 || total <= processed {
+		return "--"
+	}
+	remaining := time.Duration(float64(total-processed)/fps) * time.Second
+	return remaining.Round(time.Second).String()
+}
+
+// ffmpegProgress runs ffmpeg to completion and parses its `-progress pipe:1`
+// stdout stream into the same
+// "processed N/total frames, X fps, ETA ..." line used by the frame-analysis
+// worker pool, instead of just echoing raw key=value pairs.
+func ffmpegProgress(opts []string, expectedTotal int) {
 	cmd := exec.Command("ffmpeg", opts...)
 
 	stdout, err := cmd.StdoutPipe()
@@ -226,22 +306,93 @@ func ffmpeg(opts ...string) {
 		os.Exit(1)
 	}
 
-	go pipeReader("ffmpeg", stdout)
 	go pipeReader("ffmpeg", stderr)
+	go reportFFmpegProgress(stdout, expectedTotal)
 
-	err = cmd.Start()
-	if err != nil {
+	if err := cmd.Start(); err != nil {
 		fmt.Fprintln(os.Stderr, "Error starting ffmpeg", err)
 		os.Exit(1)
 	}
 
-	err = cmd.Wait()
-	if err != nil {
+	if err := cmd.Wait(); err != nil {
 		fmt.Fprintln(os.Stderr, "Error waiting for ffmpeg", err)
 		os.Exit(1)
 	}
 }
 
+// reportFFmpegProgress parses ffmpeg's `-progress pipe:1` key=value stream,
+// tracking the `frame=` key, and prints a throttled (once/second) progress
+// line to stderr.
+func reportFFmpegProgress(r io.Reader, expectedTotal int) {
+	scanner := bufio.NewScanner(r)
+	start := time.Now()
+	lastReport := start
+	frame := 0
+
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		if kv[0] == "frame" {
+			if n, err := strconv.Atoi(strings.TrimSpace(kv[1])); err == nil {
+				frame = n
+			}
+		}
+
+		if time.Since(lastReport) < time.Second {
+			continue
+		}
+		lastReport = time.Now()
+
+		fmt.Fprintln(os.Stderr, progressLine(frame, expectedTotal, time.Since(start)))
+	}
+}
+
+// ffmpegPipe starts ffmpeg the same way as ffmpegProgress, but returns its
+// stdout as a ReadCloser instead of parsing it, so the caller can stream raw frame data
+// straight out of the process.
+func ffmpegPipe(opts ...string) io.ReadCloser {
+	cmd := exec.Command("ffmpeg", opts...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating StdoutPipe for Cmd", err)
+		os.Exit(1)
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error creating StderrPipe for Cmd", err)
+		os.Exit(1)
+	}
+
+	go pipeReader("ffmpeg", stderr)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error starting ffmpeg", err)
+		os.Exit(1)
+	}
+
+	return &cmdStdout{stdout, cmd}
+}
+
+// cmdStdout wraps an ffmpeg process's stdout pipe so that closing it also
+// reaps the underlying process.
+type cmdStdout struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (c *cmdStdout) Close() error {
+	err := c.ReadCloser.Close()
+	if waitErr := c.cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
+
 func isDir(dir string) (bool, error) {
 	src, err := os.Stat(dir)
 
@@ -282,9 +433,9 @@ func createThumbs(input string, frameDir string) {
 	}
 
 	outFormat := frameDir + "img%06d.png"
-	filter := fmt.Sprintf("fps=%s,scale=-2:%d", framerate, thumbHeight)
+	filter := fmt.Sprintf("%sfps=%s,scale=-2:%d", rotationFilter(sourceInfo.Rotation), framerate, thumbHeight)
 	opts := []string{"-progress", "pipe:1", "-i", input, "-vf", filter, outFormat}
-	ffmpeg(opts...)
+	ffmpegProgress(opts, expectedFrameCount)
 }
 
 func getAverage(pixels RGBList) RGB {
@@ -320,6 +471,84 @@ func getAverage(pixels RGBList) RGB {
 	return result
 }
 
+// getAverageLinear averages pixels in linear light: each sRGB-encoded byte is
+// decoded with the EOTF, accumulated as float64, and the result re-encoded
+// with the OETF. This avoids the muddy grays that averaging gamma-encoded
+// bytes directly produces on saturated inputs. Unlike getAverageLab, this
+// never leaves RGB for XYZ, so the working colorspace's primaries matrix
+// doesn't factor in and -colorspace has no effect here.
+func getAverageLinear(pixels RGBList) RGB {
+	var r, g, b float64
+	total := float64(len(pixels))
+
+	for _, p := range pixels {
+		r += colorspace.EOTF(float64(p.R()) / 255)
+		g += colorspace.EOTF(float64(p.G()) / 255)
+		b += colorspace.EOTF(float64(p.B()) / 255)
+	}
+
+	return RGB{
+		toByte(colorspace.OETF(r / total)),
+		toByte(colorspace.OETF(g / total)),
+		toByte(colorspace.OETF(b / total)),
+	}
+}
+
+// getAverageLab averages pixels in CIE L*a*b*, converting through linear RGB
+// and XYZ first. Perceptual averaging in Lab holds up noticeably better than
+// linear-RGB averaging on high-contrast, high-saturation frames.
+func getAverageLab(pixels RGBList, space colorspace.Space) RGB {
+	var ls, as, bs float64
+	total := float64(len(pixels))
+
+	for _, p := range pixels {
+		r := colorspace.EOTF(float64(p.R()) / 255)
+		g := colorspace.EOTF(float64(p.G()) / 255)
+		b := colorspace.EOTF(float64(p.B()) / 255)
+
+		x, y, z := space.ToXYZ(r, g, b)
+		l, a, bb := colorspace.XYZToLab(x, y, z)
+
+		ls += l
+		as += a
+		bs += bb
+	}
+
+	x, y, z := colorspace.LabToXYZ(ls/total, as/total, bs/total)
+	r, g, b := space.FromXYZ(x, y, z)
+
+	return RGB{
+		toByte(colorspace.OETF(r)),
+		toByte(colorspace.OETF(g)),
+		toByte(colorspace.OETF(b)),
+	}
+}
+
+// toByte clamps a gamma-encoded [0,1] channel value (Lab round-trips can land
+// slightly out of gamut) and quantizes it to an 8-bit byte.
+func toByte(c float64) uint8 {
+	if c < 0 {
+		c = 0
+	}
+	if c > 1 {
+		c = 1
+	}
+	return uint8(math.Round(c * 255))
+}
+
+// computeAverage picks the averaging algorithm selected by the -lab/-linear/
+// -avg-square flags.
+func computeAverage(pixels RGBList) RGB {
+	switch {
+	case useLab:
+		return getAverageLab(pixels, workingSpace)
+	case useLinear:
+		return getAverageLinear(pixels)
+	default:
+		return getAverage(pixels)
+	}
+}
+
 func getMedian(pixels RGBList) RGB {
 	list := make(RGBList, len(pixels))
 	copy(list, pixels)
@@ -372,6 +601,219 @@ func getMode(pixels RGBList) RGBList {
 	return result
 }
 
+// quantizeColor bins each channel of p down to the given number of bits, so
+// near-identical colors collapse to the same histogram bucket before
+// clustering.
+func quantizeColor(p RGB, bits uint) RGB {
+	if bits == 0 || bits >= 8 {
+		return p
+	}
+
+	mask := uint8(0xFF << (8 - bits))
+	return RGB{p[0] & mask, p[1] & mask, p[2] & mask}
+}
+
+// getKMeans clusters the frame's pixels into k dominant colors. Pixels are
+// first collapsed into a color histogram (quantized to quantizeBits/channel
+// when set) so clustering runs over the distinct colors actually present
+// rather than every pixel, then clustered in linear RGB, or in Lab when -lab
+// is set.
+func getKMeans(pixels RGBList, k int, quantizeBits uint) RGBList {
+	hist := make(map[RGB]float64)
+	for _, p := range pixels {
+		hist[quantizeColor(p, quantizeBits)]++
+	}
+
+	samples := make([]kmeans.Sample, 0, len(hist))
+	for c, weight := range hist {
+		r := colorspace.EOTF(float64(c.R()) / 255)
+		g := colorspace.EOTF(float64(c.G()) / 255)
+		b := colorspace.EOTF(float64(c.B()) / 255)
+
+		var pt kmeans.Point
+		if useLab {
+			x, y, z := workingSpace.ToXYZ(r, g, b)
+			l, a, bb := colorspace.XYZToLab(x, y, z)
+			pt = kmeans.Point{l, a, bb}
+		} else {
+			pt = kmeans.Point{r, g, b}
+		}
+
+		samples = append(samples, kmeans.Sample{Point: pt, Weight: weight})
+	}
+
+	clusters := kmeans.Run(samples, k, kmeans.DefaultOptions())
+
+	result := make(RGBList, len(clusters))
+	for i, c := range clusters {
+		var r, g, b float64
+		if useLab {
+			x, y, z := colorspace.LabToXYZ(c.Centroid[0], c.Centroid[1], c.Centroid[2])
+			r, g, b = workingSpace.FromXYZ(x, y, z)
+		} else {
+			r, g, b = c.Centroid[0], c.Centroid[1], c.Centroid[2]
+		}
+		result[i] = RGB{toByte(colorspace.OETF(r)), toByte(colorspace.OETF(g)), toByte(colorspace.OETF(b))}
+	}
+
+	sort.Sort(result)
+	return result
+}
+
+// ycbcrToPixels flattens a planar YCbCr frame into the same RGBList shape
+// getPixels produces from a decoded image file.
+func ycbcrToPixels(img *image.YCbCr) RGBList {
+	bounds := img.Bounds()
+	w, h := bounds.Max.X, bounds.Max.Y
+
+	pixels := make(RGBList, 0, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pixels = append(pixels, rgbaToPixel(img.At(x, y).RGBA()))
+		}
+	}
+
+	return pixels
+}
+
+// logMu serializes the per-frame diagnostic lines analyzeFrame prints, since
+// it now runs concurrently across the worker pool and interleaved
+// Printf/Println calls from different frames would otherwise garble a
+// single frame's line.
+var logMu sync.Mutex
+
+// analyzeFrame runs whichever of avg/median/mode/kmeans were requested
+// against a single frame's pixels and prints the same per-frame summary
+// processFrames/processFramesPipe always have.
+func analyzeFrame(path string, pixels RGBList) Frame {
+	var avg, median RGB
+	var mode, dominant RGBList
+	var out strings.Builder
+
+	if genAvg {
+		avg = computeAverage(pixels)
+		fmt.Fprintf(&out, "  %s Average: %s\n", path, avg.Hex())
+	}
+
+	if genMed {
+		median = getMedian(pixels)
+		fmt.Fprintf(&out, "  %s Median: %s\n", path, median.Hex())
+	}
+
+	if genMode > 0 {
+		mode = getMode(pixels)
+		fmt.Fprintf(&out, "  %s Mode: ", path)
+		n := 5
+		if len(mode) < n {
+			n = len(mode)
+		}
+		for _, m := range mode[:n] {
+			fmt.Fprint(&out, m.Hex()+", ")
+		}
+
+		fmt.Fprintln(&out, "...")
+	}
+
+	if genKMeans > 0 {
+		dominant = getKMeans(pixels, genKMeans, quantizeBits)
+		fmt.Fprintf(&out, "  %s KMeans: ", path)
+		for _, c := range dominant {
+			fmt.Fprint(&out, c.Hex()+", ")
+		}
+		fmt.Fprintln(&out)
+	}
+
+	if out.Len() > 0 {
+		logMu.Lock()
+		fmt.Print(out.String())
+		logMu.Unlock()
+	}
+
+	return Frame{path, avg, median, mode, dominant}
+}
+
+// frameJob is one unit of work for the analysis worker pool: either a disk
+// path to decode (diskPath set) or an already-decoded pipe frame (img set).
+type frameJob struct {
+	index    int
+	relPath  string
+	diskPath string
+	img      *image.YCbCr
+}
+
+type indexedFrame struct {
+	index int
+	frame Frame
+}
+
+// frameWorker decodes (if necessary) and analyses frames off of jobs,
+// reporting each completed frame to results and to tracker.
+func frameWorker(jobs <-chan frameJob, results chan<- indexedFrame, wg *sync.WaitGroup, tracker *progressTracker) {
+	defer wg.Done()
+
+	for job := range jobs {
+		var pixels RGBList
+		if job.img != nil {
+			pixels = ycbcrToPixels(job.img)
+		} else {
+			p, err := getPixels(job.diskPath)
+			if err != nil {
+				fmt.Println("Error: Image could not be decoded")
+				os.Exit(1)
+			}
+			pixels = p
+		}
+
+		frame := analyzeFrame(job.relPath, pixels)
+		results <- indexedFrame{job.index, frame}
+		tracker.increment()
+	}
+}
+
+// collectFrames fans jobs out across numWorkers goroutines, reassembles
+// results in index order regardless of completion order, and prints a
+// throttled progress line while it runs. expectedTotal only needs to be a
+// reasonable estimate; it's used for the fps/ETA line, not for sizing.
+func collectFrames(jobs <-chan frameJob, expectedTotal int) []Frame {
+	workers := numWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make(chan indexedFrame, workers*2)
+	tracker := newProgressTracker(expectedTotal)
+	done := make(chan struct{})
+	go tracker.run(done)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go frameWorker(jobs, results, &wg, tracker)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	byIndex := make(map[int]Frame)
+	maxIndex := -1
+	for r := range results {
+		byIndex[r.index] = r.frame
+		if r.index > maxIndex {
+			maxIndex = r.index
+		}
+	}
+	close(done)
+
+	frames := make([]Frame, maxIndex+1)
+	for i, f := range byIndex {
+		frames[i] = f
+	}
+
+	return frames
+}
+
 func processFrames(frameDir string) []Frame {
 	fmt.Println("Generating average data for frames...")
 	files, err := ioutil.ReadDir(frameDir)
@@ -380,53 +822,66 @@ func processFrames(frameDir string) []Frame {
 		log.Fatal(err)
 	}
 
-	var result []Frame
-
+	var names []string
 	for _, file := range files {
 		if file.IsDir() {
 			continue
 		}
-		fmt.Println(file.Name() + " > ")
+		names = append(names, file.Name())
+	}
 
-		pixels, err := getPixels(frameDir + "/" + file.Name())
-		if err != nil {
-			fmt.Println("Error: Image could not be decoded")
-			os.Exit(1)
-		}
+	jobs := make(chan frameJob, len(names))
+	for i, name := range names {
+		jobs <- frameJob{index: i, relPath: "frames/" + name, diskPath: frameDir + "/" + name}
+	}
+	close(jobs)
 
-		var avg, median RGB
-		var mode RGBList
+	return collectFrames(jobs, len(names))
+}
 
-		if genAvg {
-			avg = getAverage(pixels)
-			fmt.Printf("  Average: %s\n", avg.Hex())
-		}
+// processFramesPipe drives ffmpeg directly via a Y4M pipe, analysing each
+// frame as it arrives instead of decoding PNG thumbnails from disk. Frames
+// must be read off the pipe sequentially, but analysis still fans out across
+// the worker pool.
+func processFramesPipe(input string) []Frame {
+	fmt.Println("Generating average data for frames...")
 
-		if genMed {
-			median = getMedian(pixels)
-			fmt.Printf("  Median: %s\n", median.Hex())
-		}
+	filter := fmt.Sprintf("%sfps=%s,scale=-2:%d", rotationFilter(sourceInfo.Rotation), framerate, thumbHeight)
+	opts := []string{
+		"-i", input,
+		"-f", "yuv4mpegpipe", "-pix_fmt", "yuv420p", "-vf", filter,
+		"-",
+	}
 
-		if genMode > 0 {
-			mode = getMode(pixels)
-			fmt.Printf("  Mode: ")
-			n := 5
-			if len(mode) < n {
-				n = len(mode)
+	stdout := ffmpegPipe(opts...)
+	defer stdout.Close()
+
+	reader, err := y4m.NewReader(stdout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	jobs := make(chan frameJob, numWorkers*2)
+	go func() {
+		defer close(jobs)
+		i := 0
+		for {
+			img, err := reader.NextFrame()
+			if err == io.EOF {
+				return
 			}
-			for _, m := range mode[:n] {
-				fmt.Printf(m.Hex() + ", ")
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
 			}
 
-			fmt.Println("...")
+			jobs <- frameJob{index: i, relPath: fmt.Sprintf("pipe/frame%06d", i), img: img}
+			i++
 		}
+	}()
 
-		subPath := "frames/" + file.Name()
-
-		result = append(result, Frame{subPath, avg, median, mode})
-	}
-
-	return result
+	return collectFrames(jobs, expectedFrameCount)
 }
 
 func genLineImage(frames RGBList, filename string) {
@@ -482,15 +937,26 @@ func genLineColImage(frames []RGBList, filename string) {
 	outFile.Close()
 }
 
-func getVideoDuration(filename string) int {
-	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", filename)
-
-	out, err := cmd.CombinedOutput()
-	checkErr(err, "Failed to check video duration")
+func probeVideo(filename string) probe.Info {
+	info, err := probe.Probe(filename)
+	checkErr(err, "Failed to probe video")
+	return info
+}
 
-	d, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 32)
-	checkErr(err)
-	return int(d)
+// rotationFilter returns the ffmpeg vf filter fragment (including trailing
+// comma) needed to undo a stream's rotate metadata, so frames are extracted
+// upright instead of however the camera happened to be held.
+func rotationFilter(rotation int) string {
+	switch ((rotation % 360) + 360) % 360 {
+	case 90:
+		return "transpose=1,"
+	case 180:
+		return "transpose=1,transpose=1,"
+	case 270:
+		return "transpose=2,"
+	default:
+		return ""
+	}
 }
 
 func checkErr(e error, msg ...string) {
@@ -515,6 +981,17 @@ var genAvg bool
 var avgSqr bool
 var genMed bool
 var genMode int
+var usePipe bool
+var colorSpaceName string
+var useLinear bool
+var useLab bool
+var workingSpace colorspace.Space
+var genKMeans int
+var quantizeBits uint
+var numWorkers int
+var expectedFrameCount int
+var animOutPath string
+var sourceInfo probe.Info
 
 func main() {
 	flag.StringVar(&inputFile, "i", "", "REQUIRED: Input video to be processed")
@@ -528,9 +1005,27 @@ func main() {
 	flag.BoolVar(&avgSqr, "avg-square", false, "Generate average image using squares algorithm")
 	flag.BoolVar(&genMed, "median", false, "Generate median image")
 	flag.IntVar(&genMode, "mode", 0, "Generate mode image with top N values")
+	flag.BoolVar(&usePipe, "pipe", false, "Stream frames from ffmpeg via a Y4M pipe instead of writing PNG thumbnails to disk")
+	flag.StringVar(&colorSpaceName, "colorspace", "709", "Working colorspace primaries for -lab processing (709 or 2020); -linear averaging does not use it")
+	flag.BoolVar(&useLinear, "linear", false, "Average color in linear light (gamma-correct) instead of raw sRGB bytes")
+	flag.BoolVar(&useLab, "lab", false, "Average color in CIE L*a*b* (implies linear-light processing)")
+	flag.IntVar(&genKMeans, "kmeans", 0, "Generate dominant color palette image by clustering into N colors")
+	var quantize int
+	flag.IntVar(&quantize, "quantize", 0, "Bits per channel to quantize to before -kmeans clustering (e.g. 5); 0 disables")
+	flag.IntVar(&numWorkers, "j", runtime.NumCPU(), "Number of frames to analyse in parallel")
+	flag.StringVar(&animOutPath, "anim", "", "Render an animated .gif or .apng of the average-color barcode being drawn over time")
 
 	flag.Parse()
 
+	quantizeBits = uint(quantize)
+
+	colorSpaceExplicit := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "colorspace" {
+			colorSpaceExplicit = true
+		}
+	})
+
 	inputFile = strings.ReplaceAll(inputFile, "\\", "/")
 	outDir = strings.ReplaceAll(outDir, "\\", "/")
 
@@ -552,7 +1047,11 @@ func main() {
 		}
 	}
 
-	if !genAvg && !genMed && genMode == 0 {
+	if !genAvg && !genMed && genMode == 0 && genKMeans == 0 {
+		genAvg = true
+	}
+
+	if animOutPath != "" {
 		genAvg = true
 	}
 
@@ -576,19 +1075,52 @@ func main() {
 	frameDir := fmt.Sprintf("%s/frames/", outDir)
 	jsonFile := fmt.Sprintf("%s/data.json", outDir)
 
-	duration := getVideoDuration(inputFile)
+	sourceInfo = probeVideo(inputFile)
 
-	framerate = fmt.Sprintf("%d/%d", (height / lineHeight), duration)
-	fmt.Println(framerate)
+	if !colorSpaceExplicit && sourceInfo.ColorPrimaries != "" {
+		switch sourceInfo.ColorPrimaries {
+		case "bt709":
+			colorSpaceName = "709"
+		case "bt2020":
+			colorSpaceName = "2020"
+		}
+	}
 
-	fmt.Println(framerate)
+	var csErr error
+	workingSpace, csErr = colorspace.ParseSpace(colorSpaceName)
+	if csErr != nil {
+		fmt.Println(csErr)
+		os.Exit(1)
+	}
+
+	duration := sourceInfo.Duration
 
-	createThumbs(inputFile, frameDir)
+	expectedFrameCount = height / lineHeight
+
+	if sourceInfo.FPSNum > 0 && sourceInfo.FPSDen > 0 && duration > 0 {
+		// Derive the fps= extraction rate as an exact rational rather than a
+		// lossy decimal, using the source's own frame rate to get a precise
+		// source frame count instead of trusting ffprobe's float duration.
+		sourceFrames := int(math.Round(duration * float64(sourceInfo.FPSNum) / float64(sourceInfo.FPSDen)))
+		if sourceFrames < 1 {
+			sourceFrames = 1
+		}
+		framerate = fmt.Sprintf("%d/%d", expectedFrameCount*sourceInfo.FPSNum, sourceFrames*sourceInfo.FPSDen)
+	} else {
+		framerate = fmt.Sprintf("%f", float64(expectedFrameCount)/duration)
+	}
+	fmt.Println(framerate)
 
 	image.RegisterFormat("png", "png", png.Decode, png.DecodeConfig)
 	image.RegisterFormat("jpg", "jpg", jpeg.Decode, jpeg.DecodeConfig)
 
-	frames := processFrames(frameDir)
+	var frames []Frame
+	if usePipe {
+		frames = processFramesPipe(inputFile)
+	} else {
+		createThumbs(inputFile, frameDir)
+		frames = processFrames(frameDir)
+	}
 
 	if genAvg {
 		vals := make(RGBList, len(frames))
@@ -615,13 +1147,29 @@ func main() {
 		genLineColImage(vals, outDir+filename)
 	}
 
+	if genKMeans > 0 {
+		vals := make([]RGBList, len(frames))
+		for i, f := range frames {
+			vals[i] = f.KMeans
+		}
+		filename := fmt.Sprintf("/kmeans_%d.png", genKMeans)
+		genLineColImage(vals, outDir+filename)
+	}
+
+	if animOutPath != "" {
+		if err := renderAnim(frames, animOutPath, duration); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	var jsonFrames []jsonFrame
 
 	for _, frame := range frames {
 		jsonFrames = append(jsonFrames, frame.toJSONFrame())
 	}
 
-	b, err := json.MarshalIndent(jsonFrames, "", "  ")
+	b, err := json.MarshalIndent(jsonOutput{Source: sourceInfo, Frames: jsonFrames}, "", "  ")
 
 	if err != nil {
 		fmt.Println("Error exporting to json!")
@@ -0,0 +1,173 @@
+// Package y4m parses a YUV4MPEG2 ("Y4M") stream, the format ffmpeg emits with
+// `-f yuv4mpegpipe`. It lets callers consume raw decoded frames directly off of
+// ffmpeg's stdout without ever touching disk.
+package y4m
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Header describes the global stream parameters parsed from the YUV4MPEG2
+// signature line.
+type Header struct {
+	Width       int
+	Height      int
+	FPSNum      int
+	FPSDen      int
+	Interlacing string
+	AspectNum   int
+	AspectDen   int
+	Colorspace  string
+}
+
+// Reader streams frames out of a Y4M stream.
+type Reader struct {
+	Header Header
+	r      *bufio.Reader
+}
+
+// NewReader parses the YUV4MPEG2 header line from r and returns a Reader ready
+// to yield frames via NextFrame.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("y4m: failed to read header: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "YUV4MPEG2" {
+		return nil, fmt.Errorf("y4m: not a YUV4MPEG2 stream (got %q)", line)
+	}
+
+	h := Header{
+		FPSNum:     25,
+		FPSDen:     1,
+		AspectNum:  1,
+		AspectDen:  1,
+		Colorspace: "420jpeg",
+	}
+
+	for _, f := range fields[1:] {
+		if len(f) < 2 {
+			continue
+		}
+
+		tag, val := f[0], f[1:]
+		switch tag {
+		case 'W':
+			h.Width, err = strconv.Atoi(val)
+		case 'H':
+			h.Height, err = strconv.Atoi(val)
+		case 'F':
+			err = parseRatio(val, &h.FPSNum, &h.FPSDen)
+		case 'I':
+			h.Interlacing = val
+		case 'A':
+			err = parseRatio(val, &h.AspectNum, &h.AspectDen)
+		case 'C':
+			h.Colorspace = val
+		}
+		if err != nil {
+			return nil, fmt.Errorf("y4m: invalid header field %q: %v", f, err)
+		}
+	}
+
+	if h.Width == 0 || h.Height == 0 {
+		return nil, fmt.Errorf("y4m: header missing W/H: %q", line)
+	}
+
+	return &Reader{Header: h, r: br}, nil
+}
+
+func parseRatio(s string, num, den *int) error {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected num:den, got %q", s)
+	}
+
+	n, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return err
+	}
+
+	d, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return err
+	}
+
+	*num, *den = n, d
+	return nil
+}
+
+// chromaSize returns the pixel dimensions of the U/V planes for the stream's
+// tagged colorspace.
+func (h Header) chromaSize() (int, int) {
+	switch h.Colorspace {
+	case "422":
+		return (h.Width + 1) / 2, h.Height
+	case "444":
+		return h.Width, h.Height
+	default:
+		// "420", "420jpeg", "420mpeg2", "420paldv", and unrecognized tags all
+		// fall back to 4:2:0, by far the common case.
+		return (h.Width + 1) / 2, (h.Height + 1) / 2
+	}
+}
+
+// subsampleRatio maps the stream's tagged colorspace to the matching
+// image.YCbCrSubsampleRatio.
+func (h Header) subsampleRatio() image.YCbCrSubsampleRatio {
+	switch h.Colorspace {
+	case "422":
+		return image.YCbCrSubsampleRatio422
+	case "444":
+		return image.YCbCrSubsampleRatio444
+	default:
+		return image.YCbCrSubsampleRatio420
+	}
+}
+
+// NextFrame reads and returns the next frame as a planar YCbCr image. It
+// returns io.EOF once the stream is exhausted.
+func (rd *Reader) NextFrame() (*image.YCbCr, error) {
+	line, err := rd.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line == "" {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("y4m: failed to read frame header: %v", err)
+	}
+
+	if !strings.HasPrefix(line, "FRAME") {
+		return nil, fmt.Errorf("y4m: expected FRAME marker, got %q", line)
+	}
+
+	cw, ch := rd.Header.chromaSize()
+	ySize := rd.Header.Width * rd.Header.Height
+	cSize := cw * ch
+
+	buf := make([]byte, ySize+2*cSize)
+	if _, err := io.ReadFull(rd.r, buf); err != nil {
+		return nil, fmt.Errorf("y4m: failed to read frame planes: %v", err)
+	}
+
+	img := &image.YCbCr{
+		Y:              buf[:ySize],
+		Cb:             buf[ySize : ySize+cSize],
+		Cr:             buf[ySize+cSize : ySize+2*cSize],
+		YStride:        rd.Header.Width,
+		CStride:        cw,
+		SubsampleRatio: rd.Header.subsampleRatio(),
+		Rect:           image.Rect(0, 0, rd.Header.Width, rd.Header.Height),
+	}
+
+	return img, nil
+}